@@ -12,7 +12,7 @@ package main
 
 import (
 	"bufio"
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -20,13 +20,11 @@ import (
 	"strconv"
 	"strings"
 
-	arduino "github.com/arduino/arduino-cli/cli"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/tarm/serial"
 )
 
-var cli = arduino.ArduinoCli
 var logger = log.New()
 
 type targetBoardInfo struct {
@@ -34,6 +32,30 @@ type targetBoardInfo struct {
 	Device string
 }
 
+// boardCandidate is one board arduino-cli was able to match to a port by
+// USB VID/PID, as reported by the daemon's BoardList RPC.
+type boardCandidate struct {
+	Name string
+	FQBN string
+}
+
+// MultipleBoardsDetectedError is returned when a port's USB VID/PID
+// matches more than one board/core combination and the caller hasn't
+// disambiguated with --fqbn.
+type MultipleBoardsDetectedError struct {
+	Port       string
+	Candidates []boardCandidate
+}
+
+func (e *MultipleBoardsDetectedError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "multiple boards detected on %s, pass --fqbn to disambiguate:\n", e.Port)
+	for i, c := range e.Candidates {
+		fmt.Fprintf(&b, "  %d: %s (%s)\n", i, c.Name, c.FQBN)
+	}
+	return b.String()
+}
+
 func filter(vs []string, f func(string) bool) []string {
 	vsf := make([]string, 0)
 	for _, v := range vs {
@@ -49,8 +71,14 @@ func getSketch() string {
 		return ""
 	}
 
-	sketch := os.Args[1]
+	return normalizeSketch(os.Args[1])
+}
 
+// normalizeSketch applies ardi's sketch-argument conventions (bare names
+// resolve under sketches/, trailing slashes are trimmed) to an already
+// extracted sketch argument. Subcommands that take their sketch from
+// cobra's args, rather than os.Args directly, should use this.
+func normalizeSketch(sketch string) string {
 	if !strings.Contains(sketch, "/") {
 		return fmt.Sprintf("sketches/%s", sketch)
 	}
@@ -97,43 +125,84 @@ func parseBaudRate(sketchPath string) int {
 	return baud
 }
 
-func updateCore() error {
-	cli.SetArgs([]string{"core", "update-index"})
-	if err := cli.Execute(); err != nil {
+// updateCore installs core (e.g. "arduino:avr", "esp32:esp32"), defaulting
+// to "arduino:avr" when none is pinned by an ardi.json config.
+func updateCore(core string) error {
+	if core == "" {
+		core = "arduino:avr"
+	}
+
+	client, err := getDaemonClient(context.Background())
+	if err != nil {
 		return err
 	}
 
-	cli.SetArgs([]string{"core", "install", "arduino:avr"})
-	if err := cli.Execute(); err != nil {
+	ctx := context.Background()
+	onProgress := func(line string) { fmt.Print(line) }
+
+	if err := client.UpdateIndex(ctx, onProgress); err != nil {
 		return err
 	}
 
-	return nil
+	return client.PlatformInstall(ctx, core, onProgress)
 }
 
+// getRawBoardList renders the daemon's BoardList response into the same
+// plain-text shape the rest of ardi's board-selection code expects. Unlike
+// shelling out to `arduino-cli board list`, this text is rendered by ardi
+// itself from structured data, so it can't drift out from under ardi's own
+// parsing when arduino-cli changes its table layout.
 func getRawBoardList() (string, error) {
-	out := os.Stdout
-	reset := func() {
-		os.Stdout = out
+	client, err := getDaemonClient(context.Background())
+	if err != nil {
+		return "", err
 	}
-	defer reset()
 
-	r, w, _ := os.Pipe()
-	os.Stdout = w
-	buf := new(bytes.Buffer)
-
-	cli.SetArgs([]string{"board", "list"})
-	if err := cli.Execute(); err != nil {
-		w.Close()
-		r.Close()
+	ports, err := client.BoardList(context.Background())
+	if err != nil {
 		return "", err
 	}
 
-	w.Close()
-	buf.ReadFrom(r)
-	r.Close()
+	var b strings.Builder
+	b.WriteString("Port Board Name FQBN\n")
+	for _, port := range ports {
+		if len(port.Boards) == 0 {
+			fmt.Fprintf(&b, "%s Unknown Unknown\n", port.Address)
+			continue
+		}
+		for _, board := range port.Boards {
+			fmt.Fprintf(&b, "%s %s %s\n", port.Address, board.Name, board.FQBN)
+		}
+	}
+
+	return b.String(), nil
+}
+
+// getBoardCandidatesForPort asks the daemon which boards match the USB
+// VID/PID seen on port. A port the daemon matched to no board (listed as
+// "Unknown" by getRawBoardList) yields no candidates; such boards need --fqbn.
+func getBoardCandidatesForPort(port string) ([]boardCandidate, error) {
+	client, err := getDaemonClient(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	ports, err := client.BoardList(context.Background())
+	if err != nil {
+		return nil, err
+	}
 
-	return buf.String(), nil
+	for _, p := range ports {
+		if p.Address == port {
+			candidates := make([]boardCandidate, 0, len(p.Boards))
+			for _, board := range p.Boards {
+				candidates = append(candidates, boardCandidate{Name: board.Name, FQBN: board.FQBN})
+			}
+			return candidates, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no board detected on %s", port)
 }
 
 func printFilteredBoardListWithIndices(rawBoardList string) {
@@ -161,12 +230,72 @@ func getFilteredBoardList(rawBoardList string) []string {
 	})
 }
 
-func getTargetBoardInfo(filteredList []string, rawList string) (*targetBoardInfo, error) {
+// getAllDetectedPorts returns every distinct port address seen in
+// rawBoardList, including ports whose FQBN is "Unknown" and so excluded
+// from getFilteredBoardList. This is how --fqbn-only invocations (no
+// --port) resolve against a lone connected device even when arduino-cli
+// couldn't identify it, which is the whole point of the flag.
+func getAllDetectedPorts(rawBoardList string) []string {
+	var ports []string
+	seen := map[string]bool{}
+	for _, line := range strings.Split(rawBoardList, "\n") {
+		if line == "" || strings.Contains(line, "Board Name") || strings.Contains(line, "No boards found") {
+			continue
+		}
+		port := strings.Split(line, " ")[0]
+		if !seen[port] {
+			seen[port] = true
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+// getTargetBoardInfo picks the board to upload to. fqbnFlag and portFlag
+// let the caller bypass detection and the interactive prompt entirely
+// (e.g. for CI/scripting, or for boards arduino-cli reports as "Unknown"
+// and which are otherwise filtered out of filteredList).
+func getTargetBoardInfo(filteredList []string, rawList string, fqbnFlag string, portFlag string) (*targetBoardInfo, error) {
 	var boardIndex int
 	var board []string
 	target := &targetBoardInfo{}
 	listLength := len(filteredList)
 
+	if fqbnFlag != "" {
+		if portFlag != "" {
+			return &targetBoardInfo{FQBN: fqbnFlag, Device: portFlag}, nil
+		}
+		if listLength == 1 {
+			board = strings.Split(filteredList[0], " ")
+			return &targetBoardInfo{FQBN: fqbnFlag, Device: board[0]}, nil
+		}
+		// Detection couldn't identify an FQBN (or filteredList would have
+		// included it), but if there's still only one device plugged in we
+		// already know which port to use, so there's nothing left to ask.
+		allPorts := getAllDetectedPorts(rawList)
+		if len(allPorts) == 1 {
+			return &targetBoardInfo{FQBN: fqbnFlag, Device: allPorts[0]}, nil
+		}
+		if len(allPorts) == 0 {
+			return nil, errors.New("--fqbn requires --port: no boards detected")
+		}
+		return nil, fmt.Errorf("--fqbn requires --port: %d devices connected", len(allPorts))
+	}
+
+	if portFlag != "" {
+		candidates, err := getBoardCandidatesForPort(portFlag)
+		if err != nil {
+			return nil, err
+		}
+		if len(candidates) == 0 {
+			return nil, fmt.Errorf("No board detected on %s", portFlag)
+		}
+		if len(candidates) > 1 {
+			return nil, &MultipleBoardsDetectedError{Port: portFlag, Candidates: candidates}
+		}
+		return &targetBoardInfo{FQBN: candidates[0].FQBN, Device: portFlag}, nil
+	}
+
 	if listLength == 0 {
 		return nil, errors.New("No boards detected")
 	} else if listLength == 1 {
@@ -188,49 +317,84 @@ func getTargetBoardInfo(filteredList []string, rawList string) (*targetBoardInfo
 	return target, nil
 }
 
-func compileAndUpload(targetBoard *targetBoardInfo, sketch string) error {
-	cli.SetArgs([]string{"compile", "--fqbn", targetBoard.FQBN, sketch})
-	if err := cli.Execute(); err != nil {
+// compileAndUpload compiles sketch for targetBoard's FQBN and uploads it to
+// targetBoard's device, over the arduino-cli daemon rather than shelling
+// out, forwarding the daemon's streamed progress lines to stdout.
+func compileAndUpload(targetBoard *targetBoardInfo, sketch string, programmer string) error {
+	client, err := getDaemonClient(context.Background())
+	if err != nil {
 		return err
 	}
 
-	cli.SetArgs([]string{"upload", "-p", targetBoard.Device, "--fqbn", targetBoard.FQBN, sketch})
-	if err := cli.Execute(); err != nil {
+	ctx := context.Background()
+	onProgress := func(line string) { fmt.Print(line) }
+
+	if err := client.Compile(ctx, targetBoard.FQBN, sketch, "", onProgress); err != nil {
 		return err
 	}
 
-	return nil
+	return client.Upload(ctx, targetBoard.FQBN, sketch, "", targetBoard.Device, programmer, onProgress)
 }
 
+// watchLogs streams serial output from device until the port is closed or
+// reading from it fails, e.g. because the board was unplugged. It returns
+// rather than killing the process so callers (like hotplugWatch) can wait
+// for the board to reattach instead of the whole program exiting.
 func watchLogs(device string, baud int) {
 	logFields := log.Fields{"baud": baud, "device": device}
 
 	config := &serial.Config{Name: device, Baud: baud}
 	stream, err := serial.OpenPort(config)
 	if err != nil {
-		logger.WithError(err).WithFields(logFields).Fatal("Failed to read from device")
+		logger.WithError(err).WithFields(logFields).Error("Failed to open device")
 		return
 	}
+	defer stream.Close()
 
 	for {
 		var buf = make([]byte, 128)
 		n, err := stream.Read(buf)
 		if err != nil {
-			logger.WithError(err).WithFields(logFields).Fatal("Failed to read from serial port")
+			logger.WithError(err).WithFields(logFields).Warn("Lost connection to serial port")
+			return
 		}
 		fmt.Printf("%s", buf[:n])
 	}
-
 }
 
-func process(watch bool, baud int) {
+// process is ardi's default action: update core, install pinned libraries,
+// then compile and upload to the detected (or specified) board(s). Errors
+// are logged with context and returned rather than calling logger.Fatal, so
+// main can run its cleanup before the process actually exits. With --all,
+// compile and upload failures are logged by processAll and not returned.
+// baudChanged
+// reports whether --baud was explicitly set on the command line, so an
+// ardi.json-pinned baud rate doesn't silently override it.
+func process(watch bool, baud int, baudChanged bool, fqbn string, port string, programmer string, all bool) error {
 	var rawBoardList string
 	var targetBoard *targetBoardInfo
 	var err error
 	sketch := getSketch()
 
 	if sketch == "" {
-		logger.WithError(errors.New("Missing sketch arguemnet")).Fatal("Must provide a sketch name as an argument to upload")
+		err := errors.New("Missing sketch arguemnet")
+		logger.WithError(err).Error("Must provide a sketch name as an argument to upload")
+		return err
+	}
+
+	config, err := loadArdiConfig(sketch)
+	if err != nil {
+		logger.WithError(err).Error("Failed to load ardi.json")
+		return err
+	}
+	if fqbn == "" {
+		fqbn = config.FQBN
+	}
+	if programmer == "" {
+		programmer = config.Programmer
+	}
+	if !baudChanged && config.Baud != 0 {
+		baud = config.Baud
 	}
 
 	if watch {
@@ -249,48 +413,99 @@ func process(watch bool, baud int) {
 	logWithFields := logger.WithFields(logFields)
 
 	logWithFields.Info("Updating arduino core")
-	if err = updateCore(); err != nil {
-		logger.WithError(err).Fatal("Failed to update core")
+	if err = updateCore(config.Core); err != nil {
+		logger.WithError(err).Error("Failed to update core")
+		return err
+	}
+
+	if len(config.Libraries) > 0 {
+		logWithFields.Info("Installing pinned libraries")
+		if err = installLibraries(config.Libraries); err != nil {
+			logger.WithError(err).Error("Failed to install libraries")
+			return err
+		}
 	}
 
 	logWithFields.Info("Getting board list")
 	if rawBoardList, err = getRawBoardList(); err != nil {
-		logger.WithError(err).Fatal("Failed to get board list")
+		logger.WithError(err).Error("Failed to get board list")
+		return err
 	}
 
 	logWithFields.Info("Filtering board list")
 	filteredList := getFilteredBoardList(rawBoardList)
 
+	if all {
+		targets := getTargetBoards(filteredList, fqbn)
+		if len(targets) == 0 {
+			err := errors.New("No boards detected")
+			logger.WithError(err).Error("Failed to get target boards")
+			return err
+		}
+		logWithFields.WithField("target-count", len(targets)).Info("Compiling and uploading to all matching boards")
+		processAll(context.Background(), targets, sketch, watch, baud, programmer)
+		return nil
+	}
+
 	logWithFields.Info("Parsing target board")
-	if targetBoard, err = getTargetBoardInfo(filteredList, rawBoardList); err != nil {
-		logger.WithError(err).Fatal("Failed to get target board")
+	if targetBoard, err = getTargetBoardInfo(filteredList, rawBoardList, fqbn, port); err != nil {
+		logger.WithError(err).Error("Failed to get target board")
+		return err
 	}
 
 	logWithFields.WithField("target-board", *targetBoard).Info("Found target")
 	logWithFields.Info("Compiling and uploading")
-	if err := compileAndUpload(targetBoard, sketch); err != nil {
-		logger.WithError(err).Fatal("Failed to compile or upload to board")
+	if err := compileAndUpload(targetBoard, sketch, programmer); err != nil {
+		logger.WithError(err).Error("Failed to compile or upload to board")
+		return err
 	}
 
 	if watch {
 		watchLogs(targetBoard.Device, baud)
 	}
+
+	return nil
 }
 
 func main() {
+	// Registered before rootCmd.Execute() runs any of the fallible work
+	// below, so any daemon subprocess ardi spawned is still torn down when
+	// a subcommand's RunE returns an error instead of running to completion.
+	defer closeDaemonClient()
+
 	var watch bool
 	var baud int
+	var fqbn string
+	var port string
+	var programmer string
+	var all bool
 	rootCmd := &cobra.Command{
 		Use:   "ardi [sketch]",
 		Short: "Ardi uploads sketches and prints logs for a variety of arduino boards.",
 		Long: "A light wrapper around arduino-cli that offers a quick way to upload\n" +
 			"sketches and watch logs from command line for a variety of arduino boards.",
-		Run: func(cmd *cobra.Command, args []string) {
-			process(watch, baud)
+		// Failures are already logged with context via logrus at the point
+		// they occur, so cobra's own error/usage printing is redundant.
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return process(watch, baud, cmd.Flags().Changed("baud"), fqbn, port, programmer, all)
 		},
 	}
 
 	rootCmd.Flags().BoolVarP(&watch, "watch", "w", true, "watch serial port logs after uploading sketch")
 	rootCmd.Flags().IntVarP(&baud, "baud", "b", 9600, "specify sketch baud rate")
-	rootCmd.Execute()
+	rootCmd.Flags().StringVar(&fqbn, "fqbn", "", "target board FQBN, bypasses board detection")
+	rootCmd.Flags().StringVarP(&port, "port", "p", "", "target board port, bypasses the interactive board prompt")
+	rootCmd.Flags().StringVarP(&programmer, "programmer", "P", "", "external programmer to use for upload (e.g. AVRISP mkII, USBasp)")
+	rootCmd.Flags().BoolVar(&all, "all", false, "upload to every connected board matching --fqbn (or every connected board)")
+	rootCmd.AddCommand(newWatchCmd())
+	rootCmd.AddCommand(newProgrammersCmd())
+	rootCmd.AddCommand(newBurnBootloaderCmd())
+	rootCmd.AddCommand(newInitCmd())
+	if err := rootCmd.Execute(); err != nil {
+		// os.Exit skips deferred calls, so close the daemon explicitly here.
+		closeDaemonClient()
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// ardiConfigFile is the name of the per-sketch config file that pins board,
+// baud, programmer, core, and library choices for reproducible builds.
+const ardiConfigFile = "ardi.json"
+
+// ardiConfig is the contents of an ardi.json file. Every field is optional;
+// zero values mean "detect/default as usual".
+type ardiConfig struct {
+	FQBN       string   `json:"fqbn,omitempty"`
+	Baud       int      `json:"baud,omitempty"`
+	Programmer string   `json:"programmer,omitempty"`
+	Core       string   `json:"core,omitempty"`
+	Libraries  []string `json:"libraries,omitempty"`
+}
+
+// loadArdiConfig looks for an ardi.json in sketch's directory, then at the
+// current directory, returning a zero-value config (meaning "detect/default
+// as usual") if neither is present.
+func loadArdiConfig(sketch string) (*ardiConfig, error) {
+	candidates := []string{
+		filepath.Join(sketch, ardiConfigFile),
+		ardiConfigFile,
+	}
+
+	for _, path := range candidates {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		config := &ardiConfig{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, err
+		}
+		return config, nil
+	}
+
+	return &ardiConfig{}, nil
+}
+
+// installLibraries installs each of the given Arduino library names/specs
+// (e.g. "Adafruit NeoPixel" or "Adafruit NeoPixel@1.10.0") over the daemon.
+func installLibraries(libraries []string) error {
+	client, err := getDaemonClient(context.Background())
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	onProgress := func(line string) { fmt.Print(line) }
+
+	for _, lib := range libraries {
+		name, version := splitLibrarySpec(lib)
+		if err := client.LibraryInstall(ctx, name, version, onProgress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitLibrarySpec splits a "name@version" library spec, as accepted by
+// `arduino-cli lib install`, into its two parts. version is empty when
+// unspecified, meaning "install the latest version".
+func splitLibrarySpec(lib string) (name string, version string) {
+	if i := strings.LastIndex(lib, "@"); i != -1 {
+		return lib[:i], lib[i+1:]
+	}
+	return lib, ""
+}
+
+func newInitCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "init [sketch]",
+		Short: "Scaffold an ardi.json config file for a sketch",
+		Long: "Inspects the sketch and connected boards to scaffold an ardi.json\n" +
+			"pinning FQBN and baud rate, so future uploads are reproducible without\n" +
+			"relying on board auto-detection.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sketch := normalizeSketch(args[0])
+
+			config := &ardiConfig{Baud: parseBaudRate(sketch)}
+
+			rawBoardList, err := getRawBoardList()
+			if err != nil {
+				logger.WithError(err).Error("Failed to get board list")
+				return err
+			}
+
+			filteredList := getFilteredBoardList(rawBoardList)
+			if len(filteredList) == 1 {
+				board := strings.Split(filteredList[0], " ")
+				config.FQBN = board[len(board)-1]
+			}
+
+			data, err := json.MarshalIndent(config, "", "  ")
+			if err != nil {
+				logger.WithError(err).Error("Failed to encode ardi.json")
+				return err
+			}
+
+			path := filepath.Join(sketch, ardiConfigFile)
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				logger.WithError(err).Error("Failed to write ardi.json")
+				return err
+			}
+
+			logger.WithField("path", path).Info("Wrote ardi.json")
+			return nil
+		},
+	}
+
+	return cmd
+}
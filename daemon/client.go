@@ -0,0 +1,337 @@
+// Package daemon wraps the arduino-cli gRPC daemon so ardi can talk to
+// arduino-cli over structured RPCs instead of shelling out to the arduino-cli
+// binary and scraping its human-formatted stdout, which breaks every time
+// arduino-cli changes its table or JSON layout.
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"time"
+
+	rpc "github.com/arduino/arduino-cli/rpc/cc/arduino/cli/commands/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Client wraps a gRPC connection to an arduino-cli daemon, plus the
+// subprocess that was spawned to host it, if ardi spawned one itself.
+type Client struct {
+	conn     *grpc.ClientConn
+	cores    rpc.ArduinoCoreServiceClient
+	proc     *exec.Cmd
+	instance *rpc.Instance
+}
+
+// Dial connects to an already-running arduino-cli daemon at addr
+// (e.g. "localhost:50051"). It fails fast (rather than retrying forever,
+// like grpc-go's WithBlock does against its caller's context by default) so
+// getDaemonClient can fall back to Spawn when nothing is listening yet.
+func Dial(ctx context.Context, addr string) (*Client, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer cancel()
+
+	conn, err := grpc.DialContext(dialCtx, addr, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	if err != nil {
+		return nil, fmt.Errorf("dial arduino-cli daemon: %w", err)
+	}
+	return newClient(ctx, conn, nil)
+}
+
+// Spawn starts `arduino-cli daemon` as a subprocess listening on addr and
+// connects to it. The Client's Close method terminates the subprocess.
+func Spawn(ctx context.Context, addr string) (*Client, error) {
+	// arduino-cli's daemon command only knows --port (a bare port number,
+	// no -p shorthand), and always binds 127.0.0.1 itself.
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("spawn arduino-cli daemon: %w", err)
+	}
+
+	cmd := exec.Command("arduino-cli", "daemon", "--port", port)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("spawn arduino-cli daemon: %w", err)
+	}
+
+	var conn *grpc.ClientConn
+	for attempt := 0; attempt < 10; attempt++ {
+		dialCtx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+		conn, err = grpc.DialContext(dialCtx, addr, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+		cancel()
+		if err == nil {
+			break
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		return nil, fmt.Errorf("dial spawned arduino-cli daemon: %w", err)
+	}
+
+	return newClient(ctx, conn, cmd)
+}
+
+func newClient(ctx context.Context, conn *grpc.ClientConn, proc *exec.Cmd) (*Client, error) {
+	cores := rpc.NewArduinoCoreServiceClient(conn)
+
+	resp, err := cores.Create(ctx, &rpc.CreateRequest{})
+	if err != nil {
+		conn.Close()
+		if proc != nil {
+			proc.Process.Kill()
+		}
+		return nil, fmt.Errorf("create arduino-cli instance: %w", err)
+	}
+
+	return &Client{conn: conn, cores: cores, proc: proc, instance: resp.GetInstance()}, nil
+}
+
+// Close closes the gRPC connection and, if this Client spawned the daemon
+// itself, terminates it too.
+func (c *Client) Close() error {
+	err := c.conn.Close()
+	if c.proc != nil {
+		c.proc.Process.Kill()
+	}
+	return err
+}
+
+// BoardMatch is one board arduino-cli matched to a detected port by its USB
+// VID/PID.
+type BoardMatch struct {
+	Name string
+	FQBN string
+}
+
+// BoardPort is a single detected port, with every board arduino-cli was
+// able to match against it.
+type BoardPort struct {
+	Address string
+	Boards  []BoardMatch
+}
+
+// BoardList calls the daemon's BoardList RPC.
+func (c *Client) BoardList(ctx context.Context) ([]BoardPort, error) {
+	resp, err := c.cores.BoardList(ctx, &rpc.BoardListRequest{Instance: c.instance})
+	if err != nil {
+		return nil, fmt.Errorf("board list: %w", err)
+	}
+
+	ports := make([]BoardPort, 0, len(resp.GetPorts()))
+	for _, p := range resp.GetPorts() {
+		port := BoardPort{Address: p.GetPort().GetAddress()}
+		for _, board := range p.GetMatchingBoards() {
+			port.Boards = append(port.Boards, BoardMatch{Name: board.GetName(), FQBN: board.GetFqbn()})
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+// Compile streams a compile of sketchPath for fqbn, forwarding each output
+// line to onProgress as it arrives rather than dumping arduino-cli's own
+// stdout. buildPath, if non-empty, caches the build artifacts for reuse
+// across uploads to multiple boards of the same FQBN.
+func (c *Client) Compile(ctx context.Context, fqbn, sketchPath, buildPath string, onProgress func(string)) error {
+	stream, err := c.cores.Compile(ctx, &rpc.CompileRequest{
+		Instance:   c.instance,
+		Fqbn:       fqbn,
+		SketchPath: sketchPath,
+		BuildPath:  buildPath,
+	})
+	if err != nil {
+		return fmt.Errorf("compile: %w", err)
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("compile stream: %w", err)
+		}
+		if line := resp.GetOutStream(); len(line) > 0 && onProgress != nil {
+			onProgress(string(line))
+		}
+	}
+}
+
+// Upload streams an upload to port, optionally via an external programmer.
+// importDir, if non-empty, points at a previously compiled build cached via
+// Compile's buildPath, letting the same artifact be uploaded to several
+// boards of the same FQBN without recompiling; otherwise the build is taken
+// from arduino-cli's default build location for sketchPath. Upload itself
+// never compiles.
+func (c *Client) Upload(ctx context.Context, fqbn, sketchPath, importDir, port, programmer string, onProgress func(string)) error {
+	stream, err := c.cores.Upload(ctx, &rpc.UploadRequest{
+		Instance:   c.instance,
+		Fqbn:       fqbn,
+		SketchPath: sketchPath,
+		ImportDir:  importDir,
+		Port:       &rpc.Port{Address: port},
+		Programmer: programmer,
+	})
+	if err != nil {
+		return fmt.Errorf("upload: %w", err)
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("upload stream: %w", err)
+		}
+		if line := resp.GetOutStream(); len(line) > 0 && onProgress != nil {
+			onProgress(string(line))
+		}
+	}
+}
+
+// UpdateIndex refreshes the platform index, analogous to
+// `arduino-cli core update-index`.
+func (c *Client) UpdateIndex(ctx context.Context, onProgress func(string)) error {
+	stream, err := c.cores.UpdateIndex(ctx, &rpc.UpdateIndexRequest{Instance: c.instance})
+	if err != nil {
+		return fmt.Errorf("update index: %w", err)
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("update index stream: %w", err)
+		}
+		progress := resp.GetDownloadProgress()
+		if progress == nil || onProgress == nil {
+			continue
+		}
+		// DownloadProgress is a start/update/end oneof; only start and end
+		// carry a human-readable message worth forwarding.
+		switch {
+		case progress.GetStart() != nil:
+			onProgress(progress.GetStart().GetLabel())
+		case progress.GetEnd() != nil:
+			onProgress(progress.GetEnd().GetMessage())
+		}
+	}
+}
+
+// LibraryInstall streams installation of an Arduino library by name and,
+// optionally, a pinned version.
+func (c *Client) LibraryInstall(ctx context.Context, name, version string, onProgress func(string)) error {
+	stream, err := c.cores.LibraryInstall(ctx, &rpc.LibraryInstallRequest{
+		Instance: c.instance,
+		Name:     name,
+		Version:  version,
+	})
+	if err != nil {
+		return fmt.Errorf("library install: %w", err)
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("library install stream: %w", err)
+		}
+		if msg := resp.GetTaskProgress().GetMessage(); msg != "" && onProgress != nil {
+			onProgress(msg)
+		}
+	}
+}
+
+// PlatformInstall streams installation of a "package:architecture" platform
+// such as "arduino:avr" or "esp32:esp32", generalizing ardi's previously
+// hardcoded arduino:avr install.
+func (c *Client) PlatformInstall(ctx context.Context, platform string, onProgress func(string)) error {
+	pkg, arch := splitPlatform(platform)
+	stream, err := c.cores.PlatformInstall(ctx, &rpc.PlatformInstallRequest{
+		Instance:        c.instance,
+		PlatformPackage: pkg,
+		Architecture:    arch,
+	})
+	if err != nil {
+		return fmt.Errorf("platform install: %w", err)
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("platform install stream: %w", err)
+		}
+		if msg := resp.GetTaskProgress().GetMessage(); msg != "" && onProgress != nil {
+			onProgress(msg)
+		}
+	}
+}
+
+// Programmer is one external programmer (ISP/JTAG) compatible with a board.
+type Programmer struct {
+	ID   string
+	Name string
+}
+
+// BoardDetails returns the programmers compatible with fqbn.
+func (c *Client) BoardDetails(ctx context.Context, fqbn string) ([]Programmer, error) {
+	resp, err := c.cores.BoardDetails(ctx, &rpc.BoardDetailsRequest{Instance: c.instance, Fqbn: fqbn})
+	if err != nil {
+		return nil, fmt.Errorf("board details: %w", err)
+	}
+
+	programmers := make([]Programmer, 0, len(resp.GetProgrammers()))
+	for _, p := range resp.GetProgrammers() {
+		programmers = append(programmers, Programmer{ID: p.GetId(), Name: p.GetName()})
+	}
+	return programmers, nil
+}
+
+// BurnBootloader streams a bootloader burn onto the board at port via an
+// external programmer.
+func (c *Client) BurnBootloader(ctx context.Context, fqbn, port, programmer string, onProgress func(string)) error {
+	stream, err := c.cores.BurnBootloader(ctx, &rpc.BurnBootloaderRequest{
+		Instance:   c.instance,
+		Fqbn:       fqbn,
+		Port:       &rpc.Port{Address: port},
+		Programmer: programmer,
+	})
+	if err != nil {
+		return fmt.Errorf("burn bootloader: %w", err)
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("burn bootloader stream: %w", err)
+		}
+		if line := resp.GetOutStream(); len(line) > 0 && onProgress != nil {
+			onProgress(string(line))
+		}
+	}
+}
+
+// splitPlatform splits a "package:architecture" identifier such as
+// "arduino:avr" into its two parts.
+func splitPlatform(platform string) (pkg string, arch string) {
+	for i := 0; i < len(platform); i++ {
+		if platform[i] == ':' {
+			return platform[:i], platform[i+1:]
+		}
+	}
+	return platform, ""
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Tms-bodnar/ardi/daemon"
+)
+
+// daemonAddr is the local address ardi dials (or spawns arduino-cli's
+// daemon on) to talk to arduino-cli over gRPC.
+const daemonAddr = "localhost:50051"
+
+var (
+	daemonClientOnce sync.Once
+	daemonClient     *daemon.Client
+	daemonClientErr  error
+)
+
+// getDaemonClient dials an already-running arduino-cli daemon, spawning one
+// itself if none is listening, and reuses the same client for the life of
+// the process.
+func getDaemonClient(ctx context.Context) (*daemon.Client, error) {
+	daemonClientOnce.Do(func() {
+		if c, err := daemon.Dial(ctx, daemonAddr); err == nil {
+			daemonClient = c
+			return
+		}
+		daemonClient, daemonClientErr = daemon.Spawn(ctx, daemonAddr)
+	})
+	return daemonClient, daemonClientErr
+}
+
+// closeDaemonClient closes the daemon client if one was ever dialed or
+// spawned, tearing down any `arduino-cli daemon` subprocess ardi started
+// rather than leaving it running after ardi exits.
+func closeDaemonClient() {
+	if daemonClient != nil {
+		daemonClient.Close()
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tarm/serial"
+)
+
+// ansiColors cycles through a handful of terminal colors so each board's
+// watched output gets a distinct prefix when --all uploads to more than
+// one board at once.
+var ansiColors = []string{"\033[36m", "\033[35m", "\033[33m", "\033[32m", "\033[34m"}
+
+const ansiReset = "\033[0m"
+
+// getTargetBoards returns every detected board matching fqbnFilter (or
+// every detected board, if fqbnFilter is empty), for --all uploads.
+func getTargetBoards(filteredList []string, fqbnFilter string) []*targetBoardInfo {
+	targets := make([]*targetBoardInfo, 0, len(filteredList))
+	for _, line := range filteredList {
+		board := strings.Split(line, " ")
+		device, fqbn := board[0], board[len(board)-1]
+		if fqbnFilter != "" && fqbn != fqbnFilter {
+			continue
+		}
+		targets = append(targets, &targetBoardInfo{Device: device, FQBN: fqbn})
+	}
+	return targets
+}
+
+// watchLogsCtx is watchLogs with a colored output prefix and early exit via
+// ctx, so multiple boards' logs can be multiplexed into a single stdout
+// stream and torn down together.
+func watchLogsCtx(ctx context.Context, device string, baud int, prefix string) {
+	logFields := log.Fields{"baud": baud, "device": device}
+
+	config := &serial.Config{Name: device, Baud: baud}
+	stream, err := serial.OpenPort(config)
+	if err != nil {
+		logger.WithError(err).WithFields(logFields).Error("Failed to open device")
+		return
+	}
+	defer stream.Close()
+
+	go func() {
+		<-ctx.Done()
+		stream.Close()
+	}()
+
+	for {
+		var buf = make([]byte, 128)
+		n, err := stream.Read(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.WithError(err).WithFields(logFields).Warn("Lost connection to serial port")
+			}
+			return
+		}
+		fmt.Printf("%s[%s]%s %s", prefix, device, ansiReset, buf[:n])
+	}
+}
+
+// processAll compiles sketch once per distinct FQBN among targets, caching
+// the build via --build-path, then uploads the cached artifact to every
+// matching board concurrently. When watch is set, each board's logs are
+// multiplexed into stdout with a per-device colored prefix; ctx cancels all
+// of them together.
+func processAll(ctx context.Context, targets []*targetBoardInfo, sketch string, watch bool, baud int, programmer string) {
+	client, err := getDaemonClient(ctx)
+	if err != nil {
+		logger.WithError(err).Error("Failed to connect to arduino-cli daemon")
+		return
+	}
+
+	byFQBN := map[string][]*targetBoardInfo{}
+	for _, target := range targets {
+		byFQBN[target.FQBN] = append(byFQBN[target.FQBN], target)
+	}
+
+	var wg sync.WaitGroup
+	colorIndex := 0
+
+	for fqbn, group := range byFQBN {
+		fqbnFields := logger.WithField("fqbn", fqbn)
+
+		buildPath, err := os.MkdirTemp("", "ardi-build-*")
+		if err != nil {
+			fqbnFields.WithError(err).Error("Failed to create build cache dir")
+			continue
+		}
+
+		fqbnFields.Info("Compiling")
+		if err := client.Compile(ctx, fqbn, sketch, buildPath, func(line string) { fmt.Print(line) }); err != nil {
+			fqbnFields.WithError(err).Error("Failed to compile")
+			os.RemoveAll(buildPath)
+			continue
+		}
+
+		// uploadWG tracks only the uploads sharing buildPath, as opposed to
+		// wg, which also waits on any subsequent --watch log streaming.
+		// buildPath can (and should) be cleaned up as soon as every upload
+		// that reads from it is done, without waiting for watching to stop.
+		var uploadWG sync.WaitGroup
+
+		for _, target := range group {
+			prefix := ansiColors[colorIndex%len(ansiColors)]
+			colorIndex++
+
+			wg.Add(1)
+			uploadWG.Add(1)
+			go func(target *targetBoardInfo, prefix string) {
+				defer wg.Done()
+
+				deviceFields := logger.WithField("device", target.Device)
+				deviceFields.Info("Uploading")
+
+				err := client.Upload(ctx, target.FQBN, sketch, buildPath, target.Device, programmer, func(line string) {
+					fmt.Printf("%s[%s]%s %s", prefix, target.Device, ansiReset, line)
+				})
+				uploadWG.Done()
+
+				if err != nil {
+					deviceFields.WithError(err).Error("Failed to upload")
+					return
+				}
+
+				if watch {
+					watchLogsCtx(ctx, target.Device, baud, prefix)
+				}
+			}(target, prefix)
+		}
+
+		go func(buildPath string) {
+			uploadWG.Wait()
+			if err := os.RemoveAll(buildPath); err != nil {
+				logger.WithField("build-path", buildPath).WithError(err).Warn("Failed to remove build cache dir")
+			}
+		}(buildPath)
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// getProgrammersForFQBN asks the daemon which external programmers
+// (AVRISP mkII, USBasp, Atmel-ICE, ...) are available for fqbn.
+func getProgrammersForFQBN(fqbn string) ([]programmerInfo, error) {
+	client, err := getDaemonClient(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	programmers, err := client.BoardDetails(context.Background(), fqbn)
+	if err != nil {
+		return nil, err
+	}
+
+	info := make([]programmerInfo, 0, len(programmers))
+	for _, p := range programmers {
+		info = append(info, programmerInfo{ID: p.ID, Name: p.Name})
+	}
+	return info, nil
+}
+
+// programmerInfo is one external programmer compatible with a board.
+type programmerInfo struct {
+	ID   string
+	Name string
+}
+
+func newProgrammersCmd() *cobra.Command {
+	var fqbn string
+
+	cmd := &cobra.Command{
+		Use:   "programmers",
+		Short: "List available external programmers for a board",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if fqbn == "" {
+				err := errors.New("Missing --fqbn")
+				logger.WithError(err).Error("Must provide --fqbn to list programmers for")
+				return err
+			}
+
+			programmers, err := getProgrammersForFQBN(fqbn)
+			if err != nil {
+				logger.WithError(err).Error("Failed to list programmers")
+				return err
+			}
+
+			for _, p := range programmers {
+				fmt.Printf("%s: %s\n", p.ID, p.Name)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&fqbn, "fqbn", "", "FQBN to list programmers for")
+	return cmd
+}
+
+func newBurnBootloaderCmd() *cobra.Command {
+	var fqbn string
+	var port string
+	var programmer string
+
+	cmd := &cobra.Command{
+		Use:   "burn-bootloader",
+		Short: "Burn the bootloader onto a board using an external programmer",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if fqbn == "" || port == "" || programmer == "" {
+				err := errors.New("Missing required flag")
+				logger.WithError(err).Error("Must provide --fqbn, --port, and --programmer")
+				return err
+			}
+
+			client, err := getDaemonClient(context.Background())
+			if err != nil {
+				logger.WithError(err).Error("Failed to connect to arduino-cli daemon")
+				return err
+			}
+
+			err = client.BurnBootloader(context.Background(), fqbn, port, programmer, func(line string) {
+				fmt.Print(line)
+			})
+			if err != nil {
+				logger.WithError(err).Error("Failed to burn bootloader")
+				return err
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&fqbn, "fqbn", "", "target board FQBN")
+	cmd.Flags().StringVarP(&port, "port", "p", "", "target board port")
+	cmd.Flags().StringVarP(&programmer, "programmer", "P", "", "external programmer to use")
+	return cmd
+}
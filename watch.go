@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// hotplugPollInterval is how often hotplugWatch re-runs `board list` to
+// notice boards being attached or removed.
+const hotplugPollInterval = 2 * time.Second
+
+// hotplugWatch polls the board list on an interval and, whenever a board
+// matching fqbn (or any board, if fqbn is empty) is (re)connected, compiles
+// and uploads sketch to it and starts watching its logs. It runs until the
+// process is interrupted, re-uploading on every reattach instead of exiting
+// on the first unplug.
+func hotplugWatch(sketch string, fqbn string, baud int, programmer string) {
+	known := map[string]bool{}
+
+	for {
+		rawBoardList, err := getRawBoardList()
+		if err != nil {
+			logger.WithError(err).Warn("Failed to poll board list")
+			time.Sleep(hotplugPollInterval)
+			continue
+		}
+
+		current := map[string]string{}
+		for _, line := range getFilteredBoardList(rawBoardList) {
+			board := strings.Split(line, " ")
+			device, boardFQBN := board[0], board[len(board)-1]
+			if fqbn != "" && boardFQBN != fqbn {
+				continue
+			}
+			current[device] = boardFQBN
+		}
+
+		for device, boardFQBN := range current {
+			if known[device] {
+				continue
+			}
+
+			logFields := logger.WithField("device", device)
+			logFields.Info("Board attached, compiling and uploading")
+			target := &targetBoardInfo{Device: device, FQBN: boardFQBN}
+			if err := compileAndUpload(target, sketch, programmer); err != nil {
+				logFields.WithError(err).Error("Failed to compile or upload to board")
+				continue
+			}
+			go watchLogs(device, baud)
+		}
+
+		for device := range known {
+			if _, ok := current[device]; !ok {
+				logger.WithField("device", device).Info("Board detached, waiting for reattach")
+			}
+		}
+
+		known = map[string]bool{}
+		for device := range current {
+			known[device] = true
+		}
+
+		time.Sleep(hotplugPollInterval)
+	}
+}
+
+func newWatchCmd() *cobra.Command {
+	var baud int
+	var fqbn string
+	var programmer string
+
+	cmd := &cobra.Command{
+		Use:   "watch [sketch]",
+		Short: "Recompile and reupload a sketch every time a matching board is connected",
+		Long: "Polls for connected boards and, whenever one is (re)connected, compiles\n" +
+			"and uploads sketch to it before watching its logs. Useful for boards that\n" +
+			"get unplugged and replugged during development, since a simple disconnect\n" +
+			"no longer kills ardi.",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			sketch := normalizeSketch(args[0])
+			hotplugWatch(sketch, fqbn, baud, programmer)
+		},
+	}
+
+	cmd.Flags().IntVarP(&baud, "baud", "b", 9600, "specify sketch baud rate")
+	cmd.Flags().StringVar(&fqbn, "fqbn", "", "only watch for boards matching this FQBN")
+	cmd.Flags().StringVarP(&programmer, "programmer", "P", "", "external programmer to use for upload")
+	return cmd
+}